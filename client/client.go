@@ -0,0 +1,251 @@
+// Package client provides a WebSocket client that dials out to a server,
+// built on the same connection and handler API as the server package so a
+// single WSHandler implementation can be used on either end.
+package client
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mrborghini/simple-go-websockets/server"
+)
+
+// WSHandler is the handler interface used by connections accepted by
+// server.UpgradeToWebSocket and connections opened by Dial.
+type WSHandler = server.WSHandler
+
+// WebSocket magic string, see server.UpgradeToWebSocket.
+const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// Options configures Dial.
+type Options struct {
+	// TLSConfig is used for wss:// connections. If nil, a default
+	// configuration derived from the target host is used.
+	TLSConfig *tls.Config
+	// Header carries additional HTTP headers to send with the handshake
+	// request.
+	Header http.Header
+	// Subprotocols lists the subprotocols to offer via
+	// Sec-WebSocket-Protocol, in preference order.
+	Subprotocols []string
+	// Proxy selects the proxy used to reach the server. Defaults to
+	// http.ProxyFromEnvironment.
+	Proxy func(*http.Request) (*url.URL, error)
+	// Config carries the same optional behavior UpgradeToWebSocket takes
+	// on the server side: read/write deadlines, PONG timeout, max message
+	// size, and outbound queue tuning. Compression, Subprotocols and Hub
+	// are ignored here; use the Subprotocols field above instead.
+	Config server.UpgradeConfig
+}
+
+// Dial opens a WebSocket connection to addr (ws:// or wss://) and starts
+// delivering events to handler. The returned connection can be used as
+// soon as Dial returns.
+func Dial(ctx context.Context, addr string, handler WSHandler, opts *Options) (*server.WSConn, error) {
+	if opts == nil {
+		opts = &Options{}
+	}
+
+	u, err := url.Parse(addr)
+	if err != nil {
+		return nil, fmt.Errorf("websocket: invalid url: %w", err)
+	}
+
+	var defaultPort string
+	switch u.Scheme {
+	case "ws":
+		defaultPort = "80"
+	case "wss":
+		defaultPort = "443"
+	default:
+		return nil, fmt.Errorf("websocket: unsupported scheme %q", u.Scheme)
+	}
+	port := u.Port()
+	if port == "" {
+		port = defaultPort
+	}
+
+	proxyFunc := opts.Proxy
+	if proxyFunc == nil {
+		proxyFunc = http.ProxyFromEnvironment
+	}
+
+	conn, err := dialConn(ctx, u, net.JoinHostPort(u.Hostname(), port), proxyFunc)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme == "wss" {
+		tlsConfig := opts.TLSConfig
+		if tlsConfig == nil {
+			tlsConfig = &tls.Config{ServerName: u.Hostname()}
+		} else if tlsConfig.ServerName == "" {
+			cfg := tlsConfig.Clone()
+			cfg.ServerName = u.Hostname()
+			tlsConfig = cfg
+		}
+		tlsConn := tls.Client(conn, tlsConfig)
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	key, err := makeSecWebSocketKey()
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if err := sendHandshakeRequest(conn, u, key, opts); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	subprotocol, err := readHandshakeResponse(br, key, opts.Subprotocols)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	wsConn := server.RunClientConn(&bufferedConn{r: br, conn: conn}, handler, subprotocol, opts.Config)
+	return &wsConn, nil
+}
+
+func dialConn(ctx context.Context, u *url.URL, target string, proxyFunc func(*http.Request) (*url.URL, error)) (net.Conn, error) {
+	var dialer net.Dialer
+
+	req := &http.Request{URL: u}
+	proxyURL, err := proxyFunc(req)
+	if err != nil {
+		return nil, err
+	}
+	if proxyURL == nil {
+		return dialer.DialContext(ctx, "tcp", target)
+	}
+
+	conn, err := dialer.DialContext(ctx, "tcp", proxyURL.Host)
+	if err != nil {
+		return nil, err
+	}
+
+	connectReq := fmt.Sprintf("CONNECT %s HTTP/1.1\r\nHost: %s\r\n\r\n", target, target)
+	if _, err := conn.Write([]byte(connectReq)); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, &http.Request{Method: "CONNECT"})
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("websocket: proxy CONNECT failed with status %s", resp.Status)
+	}
+	if br.Buffered() > 0 {
+		conn.Close()
+		return nil, fmt.Errorf("websocket: unexpected data buffered after proxy CONNECT")
+	}
+
+	return conn, nil
+}
+
+func makeSecWebSocketKey() (string, error) {
+	raw := make([]byte, 16)
+	if _, err := rand.Read(raw); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(raw), nil
+}
+
+func sendHandshakeRequest(conn net.Conn, u *url.URL, key string, opts *Options) error {
+	requestPath := u.RequestURI()
+	if requestPath == "" {
+		requestPath = "/"
+	}
+
+	var req strings.Builder
+	fmt.Fprintf(&req, "GET %s HTTP/1.1\r\n", requestPath)
+	fmt.Fprintf(&req, "Host: %s\r\n", u.Host)
+	req.WriteString("Upgrade: websocket\r\n")
+	req.WriteString("Connection: Upgrade\r\n")
+	fmt.Fprintf(&req, "Sec-WebSocket-Key: %s\r\n", key)
+	req.WriteString("Sec-WebSocket-Version: 13\r\n")
+	if len(opts.Subprotocols) > 0 {
+		fmt.Fprintf(&req, "Sec-WebSocket-Protocol: %s\r\n", strings.Join(opts.Subprotocols, ", "))
+	}
+	for name, values := range opts.Header {
+		for _, v := range values {
+			fmt.Fprintf(&req, "%s: %s\r\n", name, v)
+		}
+	}
+	req.WriteString("\r\n")
+
+	_, err := conn.Write([]byte(req.String()))
+	return err
+}
+
+func readHandshakeResponse(br *bufio.Reader, key string, subprotocols []string) (string, error) {
+	resp, err := http.ReadResponse(br, &http.Request{Method: "GET"})
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		return "", fmt.Errorf("websocket: handshake failed with status %s", resp.Status)
+	}
+
+	hash := sha1.Sum([]byte(key + wsGUID))
+	wantAccept := base64.StdEncoding.EncodeToString(hash[:])
+	if resp.Header.Get("Sec-WebSocket-Accept") != wantAccept {
+		return "", fmt.Errorf("websocket: invalid Sec-WebSocket-Accept")
+	}
+
+	subprotocol := resp.Header.Get("Sec-WebSocket-Protocol")
+	if subprotocol != "" && !contains(subprotocols, subprotocol) {
+		return "", fmt.Errorf("websocket: server accepted unoffered subprotocol %q", subprotocol)
+	}
+
+	return subprotocol, nil
+}
+
+func contains(values []string, target string) bool {
+	for _, v := range values {
+		if v == target {
+			return true
+		}
+	}
+	return false
+}
+
+// bufferedConn lets us hand server.RunClientConn a connection whose reads
+// come from br, which may already hold bytes buffered past the handshake
+// response, while writes and closes still go straight to conn.
+type bufferedConn struct {
+	r    *bufio.Reader
+	conn net.Conn
+}
+
+func (b *bufferedConn) Read(p []byte) (int, error)  { return b.r.Read(p) }
+func (b *bufferedConn) Write(p []byte) (int, error) { return b.conn.Write(p) }
+func (b *bufferedConn) Close() error                { return b.conn.Close() }
+
+// SetReadDeadline and SetWriteDeadline pass straight through to the
+// underlying net.Conn, so a bufferedConn still satisfies the read/write
+// deadline enforcement in server.UpgradeConfig.
+func (b *bufferedConn) SetReadDeadline(t time.Time) error  { return b.conn.SetReadDeadline(t) }
+func (b *bufferedConn) SetWriteDeadline(t time.Time) error { return b.conn.SetWriteDeadline(t) }
@@ -0,0 +1,77 @@
+package client
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func acceptFor(key string) string {
+	hash := sha1.Sum([]byte(key + wsGUID))
+	return base64.StdEncoding.EncodeToString(hash[:])
+}
+
+func handshakeResponse(extraHeaders string) *bufio.Reader {
+	resp := "HTTP/1.1 101 Switching Protocols\r\n" +
+		"Upgrade: websocket\r\n" +
+		"Connection: Upgrade\r\n" +
+		extraHeaders +
+		"\r\n"
+	return bufio.NewReader(strings.NewReader(resp))
+}
+
+func TestReadHandshakeResponseAcceptsValidKey(t *testing.T) {
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	br := handshakeResponse(fmt.Sprintf("Sec-WebSocket-Accept: %s\r\n", acceptFor(key)))
+
+	subprotocol, err := readHandshakeResponse(br, key, nil)
+	if err != nil {
+		t.Fatalf("readHandshakeResponse: %v", err)
+	}
+	if subprotocol != "" {
+		t.Fatalf("got subprotocol %q, want none", subprotocol)
+	}
+}
+
+func TestReadHandshakeResponseRejectsWrongAccept(t *testing.T) {
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	br := handshakeResponse("Sec-WebSocket-Accept: not-the-right-value\r\n")
+
+	if _, err := readHandshakeResponse(br, key, nil); err == nil {
+		t.Fatal("expected an error for a mismatched Sec-WebSocket-Accept, got nil")
+	}
+}
+
+func TestReadHandshakeResponseRejectsUnofferedSubprotocol(t *testing.T) {
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	br := handshakeResponse(fmt.Sprintf("Sec-WebSocket-Accept: %s\r\nSec-WebSocket-Protocol: chat\r\n", acceptFor(key)))
+
+	if _, err := readHandshakeResponse(br, key, []string{"json"}); err == nil {
+		t.Fatal("expected an error for a subprotocol that wasn't offered, got nil")
+	}
+}
+
+func TestReadHandshakeResponseAcceptsOfferedSubprotocol(t *testing.T) {
+	key := "dGhlIHNhbXBsZSBub25jZQ=="
+	br := handshakeResponse(fmt.Sprintf("Sec-WebSocket-Accept: %s\r\nSec-WebSocket-Protocol: chat\r\n", acceptFor(key)))
+
+	subprotocol, err := readHandshakeResponse(br, key, []string{"json", "chat"})
+	if err != nil {
+		t.Fatalf("readHandshakeResponse: %v", err)
+	}
+	if subprotocol != "chat" {
+		t.Fatalf("got subprotocol %q, want %q", subprotocol, "chat")
+	}
+}
+
+func TestReadHandshakeResponseRejectsNonSwitchingStatus(t *testing.T) {
+	resp := "HTTP/1.1 404 Not Found\r\n\r\n"
+	br := bufio.NewReader(strings.NewReader(resp))
+
+	if _, err := readHandshakeResponse(br, "any-key", nil); err == nil {
+		t.Fatal("expected an error for a non-101 response, got nil")
+	}
+}
@@ -0,0 +1,324 @@
+// Package wsframe implements the RFC 6455 WebSocket frame format shared by
+// the server and client packages. It is internal because the frame format
+// is wire-level plumbing, not something users of this library should need
+// to touch directly.
+package wsframe
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// WebSocket opcodes as defined in RFC 6455 section 5.2.
+const (
+	OpContinuation byte = 0x0
+	OpText         byte = 0x1
+	OpBinary       byte = 0x2
+	OpClose        byte = 0x8
+	OpPing         byte = 0x9
+	OpPong         byte = 0xA
+)
+
+// Status codes from RFC 6455 section 7.4.1 used by this library.
+const (
+	CodeNormalClosure    uint16 = 1000
+	CodeNoStatusReceived uint16 = 1005
+	CodeAbnormalClosure  uint16 = 1006
+)
+
+// DefaultMaxMessageSize is the largest reassembled message this package will
+// accept when no other limit has been configured.
+const DefaultMaxMessageSize = 1 << 20 // 1 MiB
+
+// Frame is a single WebSocket frame, after unmasking.
+type Frame struct {
+	FIN     bool
+	RSV1    bool
+	Opcode  byte
+	Payload []byte
+}
+
+// Message is a fully reassembled WebSocket message (one or more frames
+// joined on their continuation), or a control frame passed through as-is.
+// RSV1 is the RSV1 bit of the first frame, used by the permessage-deflate
+// extension (RFC 7692) to mark a message as compressed.
+type Message struct {
+	Opcode  byte
+	RSV1    bool
+	Payload []byte
+}
+
+func IsControlOpcode(opcode byte) bool {
+	return opcode == OpClose || opcode == OpPing || opcode == OpPong
+}
+
+// ReadFrame reads a single WebSocket frame from r. expectMasked must be true
+// when reading frames sent by a client (which RFC 6455 section 5.1 requires
+// to be masked) and false when reading frames sent by a server (which must
+// not be masked). maxPayload bounds the payload allocation: a frame whose
+// declared length exceeds it is rejected before the payload is read, so a
+// peer can't force a multi-GB allocation with a single crafted header.
+// maxPayload <= 0 means unbounded.
+func ReadFrame(r io.Reader, expectMasked bool, maxPayload int) (*Frame, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+
+	fin := header[0]&0x80 != 0
+	rsv1 := header[0]&0x40 != 0
+	opcode := header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	payloadLen := uint64(header[1] & 0x7F)
+
+	switch payloadLen {
+	case 126:
+		ext := make([]byte, 2)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return nil, err
+		}
+		payloadLen = uint64(binary.BigEndian.Uint16(ext))
+	case 127:
+		ext := make([]byte, 8)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return nil, err
+		}
+		payloadLen = binary.BigEndian.Uint64(ext)
+	}
+
+	if IsControlOpcode(opcode) && (!fin || payloadLen > 125) {
+		return nil, fmt.Errorf("websocket: control frame must not be fragmented and must be <= 125 bytes")
+	}
+
+	if maxPayload > 0 && payloadLen > uint64(maxPayload) {
+		return nil, fmt.Errorf("websocket: frame payload of %d bytes exceeds max size of %d bytes", payloadLen, maxPayload)
+	}
+
+	if masked != expectMasked {
+		if expectMasked {
+			return nil, fmt.Errorf("websocket: received unmasked frame from client")
+		}
+		return nil, fmt.Errorf("websocket: received masked frame from server")
+	}
+
+	var payload []byte
+	if masked {
+		maskKey := make([]byte, 4)
+		if _, err := io.ReadFull(r, maskKey); err != nil {
+			return nil, err
+		}
+		payload = make([]byte, payloadLen)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, err
+		}
+		for i := range payload {
+			payload[i] ^= maskKey[i%4]
+		}
+	} else {
+		payload = make([]byte, payloadLen)
+		if _, err := io.ReadFull(r, payload); err != nil {
+			return nil, err
+		}
+	}
+
+	return &Frame{FIN: fin, RSV1: rsv1, Opcode: opcode, Payload: payload}, nil
+}
+
+// EncodeFrame appends the wire bytes for a single WebSocket frame to buf
+// (which may be nil, or scratch space from a BufferPool) and returns the
+// result. mask must be true for frames written by a client (which must
+// mask every frame with a random key) and false for frames written by a
+// server (which must never mask). rsv1 sets the RSV1 bit, which the
+// permessage-deflate extension (RFC 7692) uses to mark a data frame's
+// payload as compressed.
+func EncodeFrame(buf []byte, opcode byte, fin bool, rsv1 bool, payload []byte, mask bool) ([]byte, error) {
+	b0 := opcode & 0x0F
+	if fin {
+		b0 |= 0x80
+	}
+	if rsv1 {
+		b0 |= 0x40
+	}
+
+	payloadLen := uint64(len(payload))
+	var b1Base byte
+	if mask {
+		b1Base = 0x80
+	}
+
+	switch {
+	case payloadLen <= 125:
+		buf = append(buf, b0, b1Base|byte(payloadLen))
+	case payloadLen <= 0xFFFF:
+		buf = append(buf, b0, b1Base|126, byte(payloadLen>>8), byte(payloadLen))
+	default:
+		var ext [8]byte
+		binary.BigEndian.PutUint64(ext[:], payloadLen)
+		buf = append(buf, b0, b1Base|127)
+		buf = append(buf, ext[:]...)
+	}
+
+	if mask {
+		var maskKey [4]byte
+		if _, err := rand.Read(maskKey[:]); err != nil {
+			return nil, err
+		}
+		buf = append(buf, maskKey[:]...)
+
+		start := len(buf)
+		buf = append(buf, payload...)
+		for i := range payload {
+			buf[start+i] ^= maskKey[i%4]
+		}
+	} else {
+		buf = append(buf, payload...)
+	}
+
+	return buf, nil
+}
+
+// WriteFrame encodes a single WebSocket frame and writes it to w in one
+// call. See EncodeFrame for the parameters.
+func WriteFrame(w io.Writer, opcode byte, fin bool, rsv1 bool, payload []byte, mask bool) error {
+	frame, err := EncodeFrame(nil, opcode, fin, rsv1, payload, mask)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(frame)
+	return err
+}
+
+// DefaultWriteBufferSize is the capacity BufferPool reserves for new
+// buffers when none is given.
+const DefaultWriteBufferSize = 4096
+
+// BufferPool is a sync.Pool of reusable, append-ready byte slices, used to
+// avoid allocating a fresh buffer for every outbound frame.
+type BufferPool struct {
+	pool sync.Pool
+}
+
+// NewBufferPool creates a BufferPool whose buffers start with capacity
+// size. size <= 0 uses DefaultWriteBufferSize.
+func NewBufferPool(size int) *BufferPool {
+	if size <= 0 {
+		size = DefaultWriteBufferSize
+	}
+	return &BufferPool{
+		pool: sync.Pool{
+			New: func() any {
+				buf := make([]byte, 0, size)
+				return &buf
+			},
+		},
+	}
+}
+
+// Get returns a zero-length buffer ready to be appended to.
+func (p *BufferPool) Get() []byte {
+	return (*p.pool.Get().(*[]byte))[:0]
+}
+
+// Put returns buf to the pool for reuse.
+func (p *BufferPool) Put(buf []byte) {
+	p.pool.Put(&buf)
+}
+
+// MessageReader reads complete WebSocket messages from a single connection.
+// Unlike a bare ReadFrame loop, it keeps in-progress fragmented-message
+// state across calls to ReadMessage, so a control frame (RFC 6455 allows
+// PING/PONG/CLOSE to appear between the fragments of a data message)
+// doesn't lose the fragments read so far: it's returned on its own, and
+// the next ReadMessage call picks reassembly back up where it left off.
+// A connection's read loop should create one MessageReader and call
+// ReadMessage on it repeatedly, not construct a new one per message.
+type MessageReader struct {
+	r            io.Reader
+	maxSize      int
+	expectMasked bool
+
+	assembling bool
+	opcode     byte
+	rsv1       bool
+	buf        []byte
+}
+
+// NewMessageReader creates a MessageReader reading frames from r. See
+// ReadFrame for expectMasked; maxSize bounds both a single frame's declared
+// payload and the total size of a reassembled message.
+func NewMessageReader(r io.Reader, maxSize int, expectMasked bool) *MessageReader {
+	return &MessageReader{r: r, maxSize: maxSize, expectMasked: expectMasked}
+}
+
+// ReadMessage reads frames until a complete message has been assembled,
+// returning an error if a reassembled text/binary message would exceed
+// maxSize. Control frames are returned to the caller unchanged as soon as
+// they are read; any fragmented data message in progress is left intact
+// for the next call to resume.
+func (m *MessageReader) ReadMessage() (*Message, error) {
+	for {
+		fr, err := ReadFrame(m.r, m.expectMasked, m.maxSize)
+		if err != nil {
+			return nil, err
+		}
+
+		switch fr.Opcode {
+		case OpContinuation:
+			if !m.assembling {
+				return nil, fmt.Errorf("websocket: continuation frame without a preceding message")
+			}
+			m.buf = append(m.buf, fr.Payload...)
+			if len(m.buf) > m.maxSize {
+				m.assembling, m.buf = false, nil
+				return nil, fmt.Errorf("websocket: message exceeds max size of %d bytes", m.maxSize)
+			}
+			if fr.FIN {
+				msg := &Message{Opcode: m.opcode, RSV1: m.rsv1, Payload: m.buf}
+				m.assembling, m.buf = false, nil
+				return msg, nil
+			}
+
+		case OpText, OpBinary:
+			if m.assembling {
+				return nil, fmt.Errorf("websocket: new message started before previous one finished")
+			}
+			if fr.FIN {
+				if len(fr.Payload) > m.maxSize {
+					return nil, fmt.Errorf("websocket: message exceeds max size of %d bytes", m.maxSize)
+				}
+				return &Message{Opcode: fr.Opcode, RSV1: fr.RSV1, Payload: fr.Payload}, nil
+			}
+			m.assembling = true
+			m.opcode = fr.Opcode
+			m.rsv1 = fr.RSV1
+			m.buf = append(m.buf, fr.Payload...)
+
+		case OpPing, OpPong, OpClose:
+			return &Message{Opcode: fr.Opcode, Payload: fr.Payload}, nil
+
+		default:
+			return nil, fmt.Errorf("websocket: unsupported opcode 0x%x", fr.Opcode)
+		}
+	}
+}
+
+// ParseClosePayload extracts the status code and reason from a CLOSE
+// frame's payload, per RFC 6455 section 5.5.1.
+func ParseClosePayload(payload []byte) (uint16, string) {
+	if len(payload) < 2 {
+		return CodeNoStatusReceived, ""
+	}
+	return binary.BigEndian.Uint16(payload), string(payload[2:])
+}
+
+// EncodeClosePayload builds a CLOSE frame payload from a status code and
+// reason, per RFC 6455 section 5.5.1.
+func EncodeClosePayload(code uint16, reason string) []byte {
+	payload := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, code)
+	copy(payload[2:], reason)
+	return payload
+}
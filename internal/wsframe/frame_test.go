@@ -0,0 +1,84 @@
+package wsframe
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestReadMessageUnfragmentedEnforcesMaxSize(t *testing.T) {
+	var buf bytes.Buffer
+	payload := []byte(strings.Repeat("a", 1000))
+	if err := WriteFrame(&buf, OpText, true, false, payload, false); err != nil {
+		t.Fatalf("WriteFrame: %v", err)
+	}
+
+	mr := NewMessageReader(&buf, 10, false)
+	if _, err := mr.ReadMessage(); err == nil {
+		t.Fatal("expected ReadMessage to reject an unfragmented message over maxSize, got nil error")
+	}
+}
+
+func TestReadMessageRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	payload := []byte("hello world")
+	if err := WriteFrame(&buf, OpText, true, false, payload, false); err != nil {
+		t.Fatalf("WriteFrame: %v", err)
+	}
+
+	mr := NewMessageReader(&buf, DefaultMaxMessageSize, false)
+	msg, err := mr.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage: %v", err)
+	}
+	if msg.Opcode != OpText || !bytes.Equal(msg.Payload, payload) {
+		t.Fatalf("got opcode=%d payload=%q, want opcode=%d payload=%q", msg.Opcode, msg.Payload, OpText, payload)
+	}
+}
+
+// TestReadMessageControlFrameInterleavedWithFragments covers a PING
+// arriving between the fragments of a text message, which RFC 6455 allows
+// a conforming client to do (e.g. a keepalive firing mid-upload). The
+// control frame must come back on its own, and the fragmented message
+// must still reassemble correctly on the next call.
+func TestReadMessageControlFrameInterleavedWithFragments(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, OpText, false, false, []byte("abc"), false); err != nil {
+		t.Fatalf("WriteFrame text: %v", err)
+	}
+	if err := WriteFrame(&buf, OpPing, true, false, nil, false); err != nil {
+		t.Fatalf("WriteFrame ping: %v", err)
+	}
+	if err := WriteFrame(&buf, OpContinuation, true, false, []byte("def"), false); err != nil {
+		t.Fatalf("WriteFrame continuation: %v", err)
+	}
+
+	mr := NewMessageReader(&buf, DefaultMaxMessageSize, false)
+
+	ping, err := mr.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage (ping): %v", err)
+	}
+	if ping.Opcode != OpPing {
+		t.Fatalf("got opcode=%d, want OpPing", ping.Opcode)
+	}
+
+	msg, err := mr.ReadMessage()
+	if err != nil {
+		t.Fatalf("ReadMessage (reassembled text): %v", err)
+	}
+	if msg.Opcode != OpText || string(msg.Payload) != "abcdef" {
+		t.Fatalf("got opcode=%d payload=%q, want opcode=%d payload=%q", msg.Opcode, msg.Payload, OpText, "abcdef")
+	}
+}
+
+func TestReadFrameRejectsOversizedDeclaredLength(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteFrame(&buf, OpBinary, true, false, []byte(strings.Repeat("b", 1000)), false); err != nil {
+		t.Fatalf("WriteFrame: %v", err)
+	}
+
+	if _, err := ReadFrame(&buf, false, 10); err == nil {
+		t.Fatal("expected ReadFrame to reject a frame whose declared length exceeds maxPayload, got nil error")
+	}
+}
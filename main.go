@@ -15,8 +15,16 @@ func (h *MyWSHandler) OnMessage(conn server.WSConn, message string) {
 	conn.Send(message)
 }
 
-func (h *MyWSHandler) OnClose(conn server.WSConn) {
-	log.Println("Handler detected WebSocket closure")
+func (h *MyWSHandler) OnBinary(conn server.WSConn, data []byte) {
+	log.Printf("A new binary message of %d bytes\n", len(data))
+}
+
+func (h *MyWSHandler) OnPong(conn server.WSConn, payload []byte) {
+	log.Println("Handler received a PONG")
+}
+
+func (h *MyWSHandler) OnClose(conn server.WSConn, code uint16, reason string) {
+	log.Printf("Handler detected WebSocket closure (code=%d, reason=%q)\n", code, reason)
 }
 
 func (h *MyWSHandler) OnError(err error) {
@@ -0,0 +1,243 @@
+package server
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// deflateTrailer is the 4-byte marker RFC 7692 section 7.2.1 says
+// permessage-deflate appends to a compressed message and strips back off
+// on read.
+var deflateTrailer = []byte{0x00, 0x00, 0xff, 0xff}
+
+// deflateFinalBlock is deflateTrailer followed by an empty, final
+// (BFINAL-set) stored block. flate.Reader only reports io.EOF once it has
+// consumed a block with BFINAL set; the sync-flush marker alone ends on a
+// non-final block boundary, so feeding just deflateTrailer back to the
+// reader makes its second Read return io.ErrUnexpectedEOF instead of a
+// clean io.EOF. Appending this before decompressing gives the reader a
+// proper terminator without affecting the decompressed bytes.
+var deflateFinalBlock = []byte{0x00, 0x00, 0xff, 0xff, 0x01, 0x00, 0x00, 0xff, 0xff}
+
+// CompressionOptions configures the permessage-deflate extension (RFC 7692).
+type CompressionOptions struct {
+	// Threshold is the minimum outbound payload size, in bytes, below
+	// which a frame is sent uncompressed even though the extension was
+	// negotiated. Zero compresses every frame.
+	Threshold int
+	// Level is the compress/flate level used for outbound frames.
+	// Zero means flate.DefaultCompression.
+	Level int
+	// ClientNoContextTakeover asks the client not to reuse its
+	// compression sliding window across messages, trading ratio for
+	// lower memory use.
+	ClientNoContextTakeover bool
+	// ServerNoContextTakeover adds server_no_context_takeover to the
+	// negotiated response. Frames this side sends are compressed with a
+	// fresh sliding window per message whether or not it is set.
+	ServerNoContextTakeover bool
+}
+
+// UpgradeConfig configures optional behavior of UpgradeToWebSocket.
+type UpgradeConfig struct {
+	// Compression enables and configures the permessage-deflate
+	// extension. Nil leaves compression disabled.
+	Compression *CompressionOptions
+	// Subprotocols lists the subprotocols this handler supports, in
+	// preference order. The first entry the client also offered via
+	// Sec-WebSocket-Protocol is echoed back and exposed on
+	// WSConn.Subprotocol.
+	Subprotocols []string
+	// Hub, if set, registers every accepted connection with the hub on
+	// connect and deregisters it on close.
+	Hub *Hub
+
+	// ReadDeadline, if positive, is set as the read deadline before
+	// waiting for each inbound message; a peer that goes silent for
+	// longer than this causes the connection to be closed and OnError
+	// to fire. Zero disables the deadline.
+	ReadDeadline time.Duration
+	// WriteDeadline does the same for each outbound frame write.
+	WriteDeadline time.Duration
+	// PongTimeout, if positive, closes the connection and fires OnError
+	// once a PING has gone unanswered by a PONG for longer than this,
+	// which catches half-open TCP connections the read loop would
+	// otherwise block on forever. The check runs on each 15-second ping
+	// tick.
+	PongTimeout time.Duration
+	// MaxMessageSize overrides wsframe.DefaultMaxMessageSize for this
+	// connection's reassembled messages. Zero keeps the default.
+	MaxMessageSize int
+	// WriteBufferSize sizes the pool of buffers used to encode outbound
+	// frames. Zero uses wsframe.DefaultWriteBufferSize.
+	WriteBufferSize int
+	// OverflowPolicy controls what happens when a connection's bounded
+	// outbound queue fills up because the peer isn't reading fast
+	// enough. Zero is DropMessage.
+	OverflowPolicy OutboundOverflowPolicy
+}
+
+// compressor tracks the permessage-deflate state for one connection. A
+// connection has at most one, shared by its read loop and its WSConn. mu
+// guards flateWriter/flateReader: Send/SendBinary call compress from
+// whichever goroutine the application calls them on, so without a lock
+// concurrent sends race on the shared flate.Writer.
+type compressor struct {
+	opts CompressionOptions
+
+	mu          sync.Mutex
+	flateWriter *flate.Writer
+	flateReader io.ReadCloser
+	// inflateWindow holds the last inflated bytes (up to the 32 KiB deflate
+	// window), used as the dictionary for the next inbound message while
+	// the client keeps its context.
+	inflateWindow []byte
+}
+
+func newCompressor(opts CompressionOptions) *compressor {
+	return &compressor{opts: opts}
+}
+
+func (c *compressor) level() int {
+	if c.opts.Level == 0 {
+		return flate.DefaultCompression
+	}
+	return c.opts.Level
+}
+
+// compress deflates payload for a single message, flushing it with the
+// sync-flush marker and stripping that marker before returning. Each
+// message starts from a fresh sliding window (flate.Writer.Reset discards
+// the previous one), so outbound frames never use context takeover.
+func (c *compressor) compress(payload []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var buf bytes.Buffer
+
+	if c.opts.ServerNoContextTakeover || c.flateWriter == nil {
+		fw, err := flate.NewWriter(&buf, c.level())
+		if err != nil {
+			return nil, err
+		}
+		c.flateWriter = fw
+	} else {
+		c.flateWriter.Reset(&buf)
+	}
+
+	if _, err := c.flateWriter.Write(payload); err != nil {
+		return nil, err
+	}
+	if err := c.flateWriter.Flush(); err != nil {
+		return nil, err
+	}
+
+	return bytes.TrimSuffix(buf.Bytes(), deflateTrailer), nil
+}
+
+// decompress inflates a message payload that arrived with RSV1 set,
+// reinstating the sync-flush marker permessage-deflate strips on the wire
+// plus the empty final block flate.Reader needs to terminate cleanly.
+// Unless ClientNoContextTakeover is set, the previous messages' output is
+// given to the reader as its dictionary, so a client that keeps its window
+// across messages can refer back to it.
+func (c *compressor) decompress(payload []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	payload = append(payload, deflateFinalBlock...)
+	src := bytes.NewReader(payload)
+
+	if c.opts.ClientNoContextTakeover {
+		c.inflateWindow = nil
+	}
+	if c.flateReader == nil {
+		c.flateReader = flate.NewReaderDict(src, c.inflateWindow)
+	} else if err := c.flateReader.(flate.Resetter).Reset(src, c.inflateWindow); err != nil {
+		return nil, err
+	}
+
+	out, err := io.ReadAll(c.flateReader)
+	if err != nil {
+		return nil, err
+	}
+	if !c.opts.ClientNoContextTakeover {
+		c.inflateWindow = append(c.inflateWindow, out...)
+		if len(c.inflateWindow) > 32<<10 {
+			c.inflateWindow = c.inflateWindow[len(c.inflateWindow)-32<<10:]
+		}
+	}
+	return out, nil
+}
+
+// parseExtensions parses a Sec-WebSocket-Extensions header into a map of
+// extension name to its parameters, per RFC 7692 section 8.
+func parseExtensions(header string) map[string]map[string]string {
+	extensions := map[string]map[string]string{}
+	if header == "" {
+		return extensions
+	}
+
+	for _, offer := range strings.Split(header, ",") {
+		parts := strings.Split(offer, ";")
+		name := strings.TrimSpace(parts[0])
+		if name == "" {
+			continue
+		}
+
+		params := map[string]string{}
+		for _, part := range parts[1:] {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			kv := strings.SplitN(part, "=", 2)
+			key := strings.TrimSpace(kv[0])
+			value := ""
+			if len(kv) == 2 {
+				value = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+			}
+			params[key] = value
+		}
+		extensions[name] = params
+	}
+
+	return extensions
+}
+
+// negotiateCompression inspects the client's offered extensions against
+// config and, if permessage-deflate was offered and compression is enabled,
+// returns a compressor for the connection plus the Sec-WebSocket-Extensions
+// response value to send back.
+func negotiateCompression(extensionsHeader string, config *CompressionOptions) (*compressor, string) {
+	if config == nil {
+		return nil, ""
+	}
+
+	offer, ok := parseExtensions(extensionsHeader)["permessage-deflate"]
+	if !ok {
+		return nil, ""
+	}
+
+	opts := *config
+	if _, ok := offer["client_no_context_takeover"]; ok {
+		opts.ClientNoContextTakeover = true
+	}
+	if _, ok := offer["server_no_context_takeover"]; ok {
+		opts.ServerNoContextTakeover = true
+	}
+
+	response := "permessage-deflate"
+	if opts.ServerNoContextTakeover {
+		response += "; server_no_context_takeover"
+	}
+	if opts.ClientNoContextTakeover {
+		response += "; client_no_context_takeover"
+	}
+
+	return newCompressor(opts), response
+}
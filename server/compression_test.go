@@ -0,0 +1,50 @@
+package server
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestCompressorRoundTrip(t *testing.T) {
+	for _, noContextTakeover := range []bool{false, true} {
+		c := newCompressor(CompressionOptions{
+			ClientNoContextTakeover: noContextTakeover,
+			ServerNoContextTakeover: noContextTakeover,
+		})
+
+		want := []byte(strings.Repeat("hello ", 6))
+		compressed, err := c.compress(want)
+		if err != nil {
+			t.Fatalf("compress: %v", err)
+		}
+
+		got, err := c.decompress(compressed)
+		if err != nil {
+			t.Fatalf("decompress: %v", err)
+		}
+		if string(got) != string(want) {
+			t.Fatalf("decompress(compress(%q)) = %q", want, got)
+		}
+	}
+}
+
+// TestCompressorConcurrentCompress exercises compress from many goroutines
+// at once, the way concurrent WSConn.Send/SendBinary calls do. Run with
+// -race: before compressor gained its own mutex, this raced on the shared
+// flate.Writer.
+func TestCompressorConcurrentCompress(t *testing.T) {
+	c := newCompressor(CompressionOptions{})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := c.compress([]byte("hello hello hello")); err != nil {
+				t.Errorf("compress: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+}
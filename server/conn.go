@@ -0,0 +1,94 @@
+package server
+
+import (
+	"io"
+
+	"github.com/mrborghini/simple-go-websockets/internal/wsframe"
+)
+
+// WSConn is a handle to a single WebSocket connection, passed to WSHandler
+// callbacks. The same type is used for connections accepted by
+// UpgradeToWebSocket and connections opened by client.Dial; isClient
+// controls which side of the masking rules in RFC 6455 section 5.1 apply.
+//
+// out is a pointer so that every copy of a WSConn handed to the
+// application (one per callback invocation) shares the same outbox,
+// serializing frames written from different goroutines onto the
+// underlying connection and applying backpressure to Send/SendBinary.
+type WSConn struct {
+	conn        io.ReadWriteCloser
+	isClient    bool
+	subprotocol string
+	comp        *compressor
+	out         *outbox
+}
+
+func newWSConn(conn io.ReadWriteCloser, isClient bool, subprotocol string, comp *compressor, out *outbox) WSConn {
+	return WSConn{
+		conn:        conn,
+		isClient:    isClient,
+		subprotocol: subprotocol,
+		comp:        comp,
+		out:         out,
+	}
+}
+
+// writeFrame writes a control frame immediately, bypassing the outbound
+// queue and its overflow policy.
+func (c *WSConn) writeFrame(opcode byte, rsv1 bool, payload []byte) error {
+	return c.out.writeNow(opcode, rsv1, payload)
+}
+
+// Send sends a text frame.
+func (c *WSConn) Send(message string) error {
+	return c.sendData(wsframe.OpText, []byte(message))
+}
+
+// SendBinary sends a binary frame.
+func (c *WSConn) SendBinary(data []byte) error {
+	return c.sendData(wsframe.OpBinary, data)
+}
+
+// sendData queues a text or binary frame, deflating the payload first when
+// permessage-deflate was negotiated and the payload meets its threshold.
+// The frame is subject to the connection's outbound queue and overflow
+// policy, so a slow peer can't block the caller or OOM the server.
+func (c *WSConn) sendData(opcode byte, payload []byte) error {
+	if c.comp != nil && len(payload) >= c.comp.opts.Threshold {
+		compressed, err := c.comp.compress(payload)
+		if err != nil {
+			return err
+		}
+		return c.out.enqueue(opcode, true, compressed)
+	}
+	return c.out.enqueue(opcode, false, payload)
+}
+
+// SendPing sends a PING control frame carrying payload, which must be 125
+// bytes or shorter. The peer is expected to reply with a PONG carrying the
+// same payload.
+func (c *WSConn) SendPing(payload []byte) error {
+	return c.writeFrame(wsframe.OpPing, false, payload)
+}
+
+// CloseWithCode sends a CLOSE frame carrying code and reason, then closes
+// the underlying connection.
+func (c *WSConn) CloseWithCode(code uint16, reason string) error {
+	writeErr := c.writeFrame(wsframe.OpClose, false, wsframe.EncodeClosePayload(code, reason))
+	closeErr := c.conn.Close()
+	if writeErr != nil {
+		return writeErr
+	}
+	return closeErr
+}
+
+// Close closes the underlying connection without sending a CLOSE frame.
+func (c *WSConn) Close() error {
+	return c.conn.Close()
+}
+
+// Subprotocol returns the subprotocol negotiated during the handshake, or
+// "" if none was requested or accepted.
+func (c *WSConn) Subprotocol() string {
+	return c.subprotocol
+}
@@ -0,0 +1,151 @@
+package server
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// PubSub lets a Hub's broadcasts and room messages fan out across multiple
+// server processes, e.g. backed by Redis or NATS. Implementations should
+// not redeliver a process's own published messages back to it; Hub already
+// delivers to its local connections before publishing.
+type PubSub interface {
+	Publish(channel string, message []byte) error
+	// Subscribe registers onMessage to be called with every message
+	// published to channel by other processes.
+	Subscribe(channel string, onMessage func(message []byte)) error
+}
+
+// Hub tracks live connections, assigning each a stable ID, and offers
+// broadcast and room-based messaging on top of them. Connections can be
+// added to a Hub automatically by passing it in UpgradeConfig.Hub, or
+// manually via Register.
+type Hub struct {
+	mu        sync.RWMutex
+	conns     map[string]WSConn
+	rooms     map[string]map[string]struct{} // room -> connection IDs
+	backplane PubSub
+	nextID    uint64
+}
+
+// NewHub creates an empty Hub. Passing a PubSub backplane lets Broadcast
+// and BroadcastRoom fan out to other processes sharing the same backplane.
+func NewHub(backplane ...PubSub) *Hub {
+	h := &Hub{
+		conns: map[string]WSConn{},
+		rooms: map[string]map[string]struct{}{},
+	}
+	if len(backplane) > 0 {
+		h.backplane = backplane[0]
+		h.backplane.Subscribe("broadcast", func(message []byte) {
+			h.localBroadcast(message)
+		})
+	}
+	return h
+}
+
+// Register adds conn to the hub and returns its assigned ID.
+func (h *Hub) Register(conn WSConn) string {
+	id := strconv.FormatUint(atomic.AddUint64(&h.nextID, 1), 10)
+
+	h.mu.Lock()
+	h.conns[id] = conn
+	h.mu.Unlock()
+
+	return id
+}
+
+// Deregister removes a connection from the hub and every room it had
+// joined.
+func (h *Hub) Deregister(id string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	delete(h.conns, id)
+	for _, members := range h.rooms {
+		delete(members, id)
+	}
+}
+
+// Join adds id to room, so it receives future BroadcastRoom calls for it.
+func (h *Hub) Join(id, room string) {
+	h.mu.Lock()
+	members, created := h.rooms[room]
+	if !created {
+		members = map[string]struct{}{}
+		h.rooms[room] = members
+	}
+	members[id] = struct{}{}
+	h.mu.Unlock()
+
+	// Subscribe outside the lock: a PubSub implementation that invokes
+	// onMessage synchronously would otherwise deadlock, since
+	// localBroadcastRoom takes the same non-reentrant mutex.
+	if !created && h.backplane != nil {
+		h.backplane.Subscribe("room:"+room, func(message []byte) {
+			h.localBroadcastRoom(room, message)
+		})
+	}
+}
+
+// Leave removes id from room.
+func (h *Hub) Leave(id, room string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if members, ok := h.rooms[room]; ok {
+		delete(members, id)
+	}
+}
+
+// SendTo sends msg to a single connection by ID.
+func (h *Hub) SendTo(id string, msg string) error {
+	h.mu.RLock()
+	conn, ok := h.conns[id]
+	h.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("server: no connection registered with id %q", id)
+	}
+	return conn.Send(msg)
+}
+
+// Broadcast sends msg to every connection registered with the hub, and to
+// every other process sharing the hub's backplane, if any.
+func (h *Hub) Broadcast(msg string) {
+	h.localBroadcast([]byte(msg))
+	if h.backplane != nil {
+		h.backplane.Publish("broadcast", []byte(msg))
+	}
+}
+
+// BroadcastRoom sends msg to every connection that has Join'd room, and to
+// every other process sharing the hub's backplane, if any.
+func (h *Hub) BroadcastRoom(room string, msg string) {
+	h.localBroadcastRoom(room, []byte(msg))
+	if h.backplane != nil {
+		h.backplane.Publish("room:"+room, []byte(msg))
+	}
+}
+
+func (h *Hub) localBroadcast(msg []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for _, conn := range h.conns {
+		conn.Send(string(msg))
+	}
+}
+
+func (h *Hub) localBroadcastRoom(room string, msg []byte) {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	for id := range h.rooms[room] {
+		if conn, ok := h.conns[id]; ok {
+			conn.Send(string(msg))
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"io"
+	"sync"
+	"testing"
+)
+
+// discardConn is an io.ReadWriteCloser that accepts and discards every
+// write, enough to back a WSConn whose outbox actually has somewhere to
+// write frames to.
+type discardConn struct{}
+
+func (discardConn) Read([]byte) (int, error)    { return 0, io.EOF }
+func (discardConn) Write(p []byte) (int, error) { return len(p), nil }
+func (discardConn) Close() error                { return nil }
+
+func newTestConn() WSConn {
+	out := newOutbox(discardConn{}, false, 0, 0, DropMessage, func(error) {})
+	return newWSConn(discardConn{}, false, "", nil, out)
+}
+
+func TestHubRegisterDeregister(t *testing.T) {
+	h := NewHub()
+	conn := newTestConn()
+
+	id := h.Register(conn)
+	if id == "" {
+		t.Fatal("Register returned an empty id")
+	}
+
+	h.mu.RLock()
+	_, ok := h.conns[id]
+	h.mu.RUnlock()
+	if !ok {
+		t.Fatal("connection not found in hub after Register")
+	}
+
+	h.Deregister(id)
+
+	h.mu.RLock()
+	_, ok = h.conns[id]
+	h.mu.RUnlock()
+	if ok {
+		t.Fatal("connection still present in hub after Deregister")
+	}
+}
+
+func TestHubJoinLeaveRoom(t *testing.T) {
+	h := NewHub()
+	conn := newTestConn()
+	id := h.Register(conn)
+
+	h.Join(id, "general")
+
+	h.mu.RLock()
+	_, inRoom := h.rooms["general"][id]
+	h.mu.RUnlock()
+	if !inRoom {
+		t.Fatal("id not present in room after Join")
+	}
+
+	h.Leave(id, "general")
+
+	h.mu.RLock()
+	_, inRoom = h.rooms["general"][id]
+	h.mu.RUnlock()
+	if inRoom {
+		t.Fatal("id still present in room after Leave")
+	}
+}
+
+func TestHubDeregisterRemovesFromRooms(t *testing.T) {
+	h := NewHub()
+	conn := newTestConn()
+	id := h.Register(conn)
+	h.Join(id, "general")
+
+	h.Deregister(id)
+
+	h.mu.RLock()
+	_, inRoom := h.rooms["general"][id]
+	h.mu.RUnlock()
+	if inRoom {
+		t.Fatal("Deregister did not remove id from its rooms")
+	}
+}
+
+// TestHubConcurrentAccess exercises Register, Broadcast, Join, Leave, and
+// Deregister from many goroutines at once. Run with -race: Hub's state is
+// shared across every connection's goroutine via UpgradeConfig.Hub.
+func TestHubConcurrentAccess(t *testing.T) {
+	h := NewHub()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			conn := newTestConn()
+			id := h.Register(conn)
+			h.Join(id, "general")
+			h.Broadcast("hi")
+			h.BroadcastRoom("general", "hi")
+			h.Leave(id, "general")
+			h.Deregister(id)
+		}()
+	}
+	wg.Wait()
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/mrborghini/simple-go-websockets/internal/wsframe"
+)
+
+// OutboundOverflowPolicy controls what an outbox does with a Send/SendBinary
+// call that arrives while its queue is already full, i.e. the peer isn't
+// reading fast enough to keep up.
+type OutboundOverflowPolicy int
+
+const (
+	// DropMessage silently drops the new message, leaving the connection
+	// open.
+	DropMessage OutboundOverflowPolicy = iota
+	// CloseConnection closes the connection instead of dropping the
+	// message, on the assumption that a backed-up peer is already
+	// effectively gone.
+	CloseConnection
+)
+
+// defaultOutboxQueueSize is how many pending messages an outbox buffers
+// before applying its overflow policy.
+const defaultOutboxQueueSize = 64
+
+// netConnDeadliner is the subset of net.Conn that outbox needs to enforce
+// read/write deadlines. It is satisfied by the hijacked net.Conn on the
+// server side and by the client package's bufferedConn; connections that
+// don't implement it simply run without deadlines.
+type netConnDeadliner interface {
+	SetReadDeadline(time.Time) error
+	SetWriteDeadline(time.Time) error
+}
+
+// outbox serializes writes to a connection through a single goroutine and
+// applies backpressure: Send/SendBinary enqueue onto a bounded channel
+// instead of writing directly, so a slow reader can't block or OOM the
+// server. Control frames (ping/pong/close) bypass the queue via writeNow,
+// since they must go out promptly and are never subject to the overflow
+// policy, but still funnel through the same writeRaw so no two goroutines
+// ever write to the connection at once.
+type outbox struct {
+	conn           interface{ Write([]byte) (int, error) }
+	isClient       bool
+	writeDeadline  time.Duration
+	overflowPolicy OutboundOverflowPolicy
+	bufPool        *wsframe.BufferPool
+
+	writeMu sync.Mutex
+	queue   chan queuedFrame
+	done    chan struct{}
+	onError func(error)
+}
+
+type queuedFrame struct {
+	opcode  byte
+	rsv1    bool
+	payload []byte
+}
+
+// newOutbox creates an outbox and starts its writer goroutine. writeBufferSize
+// <= 0 uses wsframe.DefaultWriteBufferSize. onError is called from the
+// writer goroutine if a queued write fails, and from the enqueuing
+// goroutine if the queue overflows, under either policy.
+func newOutbox(conn interface{ Write([]byte) (int, error) }, isClient bool, writeDeadline time.Duration, writeBufferSize int, policy OutboundOverflowPolicy, onError func(error)) *outbox {
+	ob := &outbox{
+		conn:           conn,
+		isClient:       isClient,
+		writeDeadline:  writeDeadline,
+		overflowPolicy: policy,
+		bufPool:        wsframe.NewBufferPool(writeBufferSize),
+		queue:          make(chan queuedFrame, defaultOutboxQueueSize),
+		done:           make(chan struct{}),
+		onError:        onError,
+	}
+	go ob.run()
+	return ob
+}
+
+func (o *outbox) run() {
+	for {
+		select {
+		case fr, ok := <-o.queue:
+			if !ok {
+				return
+			}
+			if err := o.writeRaw(fr.opcode, fr.rsv1, fr.payload); err != nil {
+				o.onError(err)
+				if closer, ok := o.conn.(interface{ Close() error }); ok {
+					closer.Close()
+				}
+				return
+			}
+		case <-o.done:
+			return
+		}
+	}
+}
+
+// writeRaw encodes and writes a single frame, applying the write deadline
+// and serializing against every other write on this connection regardless
+// of whether it came through the queue or writeNow.
+func (o *outbox) writeRaw(opcode byte, rsv1 bool, payload []byte) error {
+	o.writeMu.Lock()
+	defer o.writeMu.Unlock()
+
+	if o.writeDeadline > 0 {
+		if deadliner, ok := o.conn.(netConnDeadliner); ok {
+			deadliner.SetWriteDeadline(time.Now().Add(o.writeDeadline))
+		}
+	}
+
+	buf := o.bufPool.Get()
+	defer o.bufPool.Put(buf)
+
+	buf, err := wsframe.EncodeFrame(buf, opcode, true, rsv1, payload, o.isClient)
+	if err != nil {
+		return err
+	}
+	_, err = o.conn.Write(buf)
+	return err
+}
+
+// writeNow writes a control frame immediately, bypassing the queue and its
+// overflow policy.
+func (o *outbox) writeNow(opcode byte, rsv1 bool, payload []byte) error {
+	return o.writeRaw(opcode, rsv1, payload)
+}
+
+// enqueue queues a data frame for the writer goroutine, applying the
+// overflow policy if the queue is full. The overflow is also reported
+// through onError, since callers such as Hub.Broadcast fan out to many
+// connections without checking each one's returned error.
+func (o *outbox) enqueue(opcode byte, rsv1 bool, payload []byte) error {
+	select {
+	case o.queue <- queuedFrame{opcode: opcode, rsv1: rsv1, payload: payload}:
+		return nil
+	default:
+	}
+
+	var err error
+	switch o.overflowPolicy {
+	case CloseConnection:
+		if closer, ok := o.conn.(interface{ Close() error }); ok {
+			closer.Close()
+		}
+		err = fmt.Errorf("websocket: outbound queue full, closing connection")
+	default:
+		err = fmt.Errorf("websocket: outbound queue full, dropping message")
+	}
+
+	o.onError(err)
+	return err
+}
+
+// stop shuts down the writer goroutine. It does not close the connection.
+func (o *outbox) stop() {
+	close(o.done)
+}
@@ -6,33 +6,28 @@ import (
 	"io"
 	"net/http"
 	"strings"
+	"sync/atomic"
 	"time"
+
+	"github.com/mrborghini/simple-go-websockets/internal/wsframe"
 )
 
 type WSHandler interface {
+	OnConnect(WSConn)
 	OnMessage(WSConn, string)
-	OnClose(WSConn)
+	OnBinary(WSConn, []byte)
+	OnPong(WSConn, []byte)
+	OnClose(WSConn, uint16, string)
 	OnError(error)
-	OnConnect(WSConn)
-}
-
-type WSConn struct {
-	conn io.ReadWriteCloser
-}
-
-func (c *WSConn) Send(message string) error {
-	return writeFrame(c.conn, []byte(message))
-}
-
-func (c *WSConn) Close() error {
-	return c.conn.Close()
 }
 
 // WebSocket magic string
 const wsGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
 
-// Upgrade HTTP to WebSocket
-func UpgradeToWebSocket(w http.ResponseWriter, r *http.Request, handler WSHandler) {
+// Upgrade HTTP to WebSocket. config is optional; pass an UpgradeConfig to
+// turn on features such as permessage-deflate compression or automatic
+// registration with a Hub.
+func UpgradeToWebSocket(w http.ResponseWriter, r *http.Request, handler WSHandler, config ...UpgradeConfig) {
 	// Check for WebSocket headers
 	if strings.ToLower(r.Header.Get("Upgrade")) != "websocket" ||
 		!strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") {
@@ -49,6 +44,13 @@ func UpgradeToWebSocket(w http.ResponseWriter, r *http.Request, handler WSHandle
 	hash := sha1.Sum([]byte(key + wsGUID))
 	acceptKey := base64.StdEncoding.EncodeToString(hash[:])
 
+	var cfg UpgradeConfig
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+	comp, extensionsResponse := negotiateCompression(r.Header.Get("Sec-WebSocket-Extensions"), cfg.Compression)
+	subprotocol := negotiateSubprotocol(parseSubprotocols(r.Header.Get("Sec-WebSocket-Protocol")), cfg.Subprotocols)
+
 	// Hijack connection
 	conn, _, err := w.(http.Hijacker).Hijack()
 	if err != nil {
@@ -60,7 +62,14 @@ func UpgradeToWebSocket(w http.ResponseWriter, r *http.Request, handler WSHandle
 	response := "HTTP/1.1 101 Switching Protocols\r\n" +
 		"Upgrade: websocket\r\n" +
 		"Connection: Upgrade\r\n" +
-		"Sec-WebSocket-Accept: " + acceptKey + "\r\n\r\n"
+		"Sec-WebSocket-Accept: " + acceptKey + "\r\n"
+	if extensionsResponse != "" {
+		response += "Sec-WebSocket-Extensions: " + extensionsResponse + "\r\n"
+	}
+	if subprotocol != "" {
+		response += "Sec-WebSocket-Protocol: " + subprotocol + "\r\n"
+	}
+	response += "\r\n"
 
 	_, err = conn.Write([]byte(response))
 	if err != nil {
@@ -68,120 +77,131 @@ func UpgradeToWebSocket(w http.ResponseWriter, r *http.Request, handler WSHandle
 		return
 	}
 
-	wsConn := WSConn{conn: conn}
+	out := newOutbox(conn, false, cfg.WriteDeadline, cfg.WriteBufferSize, cfg.OverflowPolicy, handler.OnError)
+	wsConn := newWSConn(conn, false, subprotocol, comp, out)
 	handler.OnConnect(wsConn) // Notify the handler of the new connection
-	go handleWebSocket(conn, handler)
+
+	var connID string
+	if cfg.Hub != nil {
+		connID = cfg.Hub.Register(wsConn)
+	}
+	go handleWebSocket(wsConn, handler, cfg, connID)
+}
+
+// RunClientConn wires up conn as the client side of a WebSocket connection
+// that has already completed the opening handshake, notifying handler and
+// starting the read loop in the background. It is used by the client
+// package, which performs the handshake itself before handing the
+// connection off. config is optional, as in UpgradeToWebSocket.
+func RunClientConn(conn io.ReadWriteCloser, handler WSHandler, subprotocol string, config ...UpgradeConfig) WSConn {
+	var cfg UpgradeConfig
+	if len(config) > 0 {
+		cfg = config[0]
+	}
+
+	out := newOutbox(conn, true, cfg.WriteDeadline, cfg.WriteBufferSize, cfg.OverflowPolicy, handler.OnError)
+	wsConn := newWSConn(conn, true, subprotocol, nil, out)
+	handler.OnConnect(wsConn)
+	go handleWebSocket(wsConn, handler, cfg, "")
+	return wsConn
 }
 
-func pingLoop(conn io.ReadWriteCloser, done <-chan struct{}) {
+// pingLoop sends a PING every 15 seconds and, if cfg.PongTimeout is set,
+// closes the connection when a PING has gone unanswered by a PONG for
+// longer than that, which catches half-open connections the read loop
+// would otherwise block on forever.
+func pingLoop(wsConn WSConn, cfg UpgradeConfig, lastPong *int64, done <-chan struct{}) {
 	ticker := time.NewTicker(15 * time.Second)
 	defer ticker.Stop()
 
+	// pendingSince is when the oldest PING not yet followed by a PONG was
+	// sent, or 0 if none is outstanding.
+	var pendingSince int64
 	for {
 		select {
 		case <-ticker.C:
-			conn.Write([]byte{0x89, 0x00}) // 0x89 = PING frame with 0 payload
+			if pendingSince != 0 && atomic.LoadInt64(lastPong) >= pendingSince {
+				pendingSince = 0
+			}
+			if cfg.PongTimeout > 0 && pendingSince != 0 && time.Since(time.Unix(0, pendingSince)) > cfg.PongTimeout {
+				wsConn.conn.Close()
+				return
+			}
+			now := time.Now().UnixNano()
+			wsConn.writeFrame(wsframe.OpPing, false, nil)
+			if pendingSince == 0 {
+				pendingSince = now
+			}
 		case <-done:
 			return
 		}
 	}
 }
 
-
-func readFrame(conn io.Reader) ([]byte, error) {
-	// Read first 2 bytes (FIN, RSV, Opcode | MASK, Payload length)
-	header := make([]byte, 2)
-	if _, err := io.ReadFull(conn, header); err != nil {
-		return nil, err
+func handleWebSocket(wsConn WSConn, handler WSHandler, cfg UpgradeConfig, connID string) {
+	defer wsConn.conn.Close()
+	if cfg.Hub != nil {
+		defer cfg.Hub.Deregister(connID)
 	}
 
-	// Extract payload length
-	payloadLen := int(header[1] & 0x7F)
-
-	// Extended payload length
-	if payloadLen == 126 {
-		extLen := make([]byte, 2)
-		if _, err := io.ReadFull(conn, extLen); err != nil {
-			return nil, err
-		}
-		payloadLen = int(extLen[0])<<8 | int(extLen[1])
-	} else if payloadLen == 127 {
-		extLen := make([]byte, 8)
-		if _, err := io.ReadFull(conn, extLen); err != nil {
-			return nil, err
-		}
-		payloadLen = int(extLen[0])<<56 | int(extLen[1])<<48 | int(extLen[2])<<40 | int(extLen[3])<<32 |
-			int(extLen[4])<<24 | int(extLen[5])<<16 | int(extLen[6])<<8 | int(extLen[7])
+	maxMessageSize := wsframe.DefaultMaxMessageSize
+	if cfg.MaxMessageSize > 0 {
+		maxMessageSize = cfg.MaxMessageSize
 	}
 
-	// Ensure the message is masked (clients **must** send masked messages)
-	// isMasked := (header[1] & 0x80) != 0
-	// Clients MUST send masked messages; servers MUST NOT.
-	// if !isMasked {
-	// 	return nil, fmt.Errorf("invalid WebSocket frame: MASK must be clear")
-	// }
-
-	// Read masking key
-	maskKey := make([]byte, 4)
-	if _, err := io.ReadFull(conn, maskKey); err != nil {
-		return nil, err
-	}
-
-	// Read payload data
-	payload := make([]byte, payloadLen)
-	if _, err := io.ReadFull(conn, payload); err != nil {
-		return nil, err
-	}
-
-	// Unmask payload
-	for i := 0; i < payloadLen; i++ {
-		payload[i] ^= maskKey[i%4]
-	}
-
-	return payload, nil
-}
-
-func writeFrame(conn io.Writer, message []byte) error {
-	// Determine the payload length encoding
-	var header []byte
-	payloadLen := len(message)
-
-	if payloadLen <= 125 {
-		header = []byte{0x81, byte(payloadLen)}
-	} else if payloadLen <= 65535 {
-		header = []byte{0x81, 126, byte(payloadLen >> 8), byte(payloadLen & 0xFF)}
-	} else {
-		header = []byte{0x81, 127,
-			byte(payloadLen >> 56), byte(payloadLen >> 48), byte(payloadLen >> 40), byte(payloadLen >> 32),
-			byte(payloadLen >> 24), byte(payloadLen >> 16), byte(payloadLen >> 8), byte(payloadLen & 0xFF)}
-	}
-
-	// Write header and payload
-	_, err := conn.Write(append(header, message...))
-	return err
-}
-
-func handleWebSocket(conn io.ReadWriteCloser, handler WSHandler) {
-	defer conn.Close()
-
-	wsConn := WSConn{conn: conn}
-	handler.OnConnect(wsConn)
-	defer handler.OnClose(wsConn)
+	lastPong := new(int64)
+	atomic.StoreInt64(lastPong, time.Now().UnixNano())
 
 	done := make(chan struct{})
+	go pingLoop(wsConn, cfg, lastPong, done)
+	defer close(done)
+	defer wsConn.out.stop()
 
-	go pingLoop(conn, done)
+	// A server expects every frame from a client to be masked; a client
+	// expects the reverse. One MessageReader for the whole connection so a
+	// control frame arriving between the fragments of a data message
+	// doesn't lose the fragments read so far.
+	msgReader := wsframe.NewMessageReader(wsConn.conn, maxMessageSize, !wsConn.isClient)
 
 	for {
-		msg, err := readFrame(conn)
+		if cfg.ReadDeadline > 0 {
+			if deadliner, ok := wsConn.conn.(netConnDeadliner); ok {
+				deadliner.SetReadDeadline(time.Now().Add(cfg.ReadDeadline))
+			}
+		}
+
+		msg, err := msgReader.ReadMessage()
 		if err != nil {
 			handler.OnError(err)
-			break
+			handler.OnClose(wsConn, wsframe.CodeAbnormalClosure, err.Error())
+			return
 		}
 
-		handler.OnMessage(wsConn, string(msg))
-	}
+		payload := msg.Payload
+		if msg.RSV1 && wsConn.comp != nil {
+			payload, err = wsConn.comp.decompress(payload)
+			if err != nil {
+				handler.OnError(err)
+				handler.OnClose(wsConn, wsframe.CodeAbnormalClosure, err.Error())
+				return
+			}
+		}
 
-	close(done)
+		switch msg.Opcode {
+		case wsframe.OpText:
+			handler.OnMessage(wsConn, string(payload))
+		case wsframe.OpBinary:
+			handler.OnBinary(wsConn, payload)
+		case wsframe.OpPing:
+			wsConn.writeFrame(wsframe.OpPong, false, payload)
+		case wsframe.OpPong:
+			atomic.StoreInt64(lastPong, time.Now().UnixNano())
+			handler.OnPong(wsConn, payload)
+		case wsframe.OpClose:
+			code, reason := wsframe.ParseClosePayload(payload)
+			handler.OnClose(wsConn, code, reason)
+			wsConn.writeFrame(wsframe.OpClose, false, msg.Payload)
+			return
+		}
+	}
 }
-
@@ -0,0 +1,65 @@
+// Package subproto provides WSHandler adapters for common WebSocket
+// subprotocols, built on top of the raw frame API in the server package.
+package subproto
+
+import (
+	"encoding/json"
+
+	"github.com/mrborghini/simple-go-websockets/server"
+)
+
+// JSONHandler is implemented by types that want to exchange JSON messages
+// over a connection that negotiated a JSON-based subprotocol.
+type JSONHandler[T any] interface {
+	OnConnect(server.WSConn)
+	OnJSON(server.WSConn, T)
+	OnClose(server.WSConn, uint16, string)
+	OnError(error)
+}
+
+// JSONCodec adapts a JSONHandler[T] into a server.WSHandler, decoding each
+// text or binary frame as JSON into a T before dispatching it to OnJSON.
+// Frames that fail to decode are reported via OnError and dropped.
+type JSONCodec[T any] struct {
+	Handler JSONHandler[T]
+}
+
+func (c *JSONCodec[T]) OnConnect(conn server.WSConn) {
+	c.Handler.OnConnect(conn)
+}
+
+func (c *JSONCodec[T]) OnMessage(conn server.WSConn, message string) {
+	c.decodeAndDispatch(conn, []byte(message))
+}
+
+func (c *JSONCodec[T]) OnBinary(conn server.WSConn, data []byte) {
+	c.decodeAndDispatch(conn, data)
+}
+
+func (c *JSONCodec[T]) decodeAndDispatch(conn server.WSConn, raw []byte) {
+	var value T
+	if err := json.Unmarshal(raw, &value); err != nil {
+		c.Handler.OnError(err)
+		return
+	}
+	c.Handler.OnJSON(conn, value)
+}
+
+func (c *JSONCodec[T]) OnPong(server.WSConn, []byte) {}
+
+func (c *JSONCodec[T]) OnClose(conn server.WSConn, code uint16, reason string) {
+	c.Handler.OnClose(conn, code, reason)
+}
+
+func (c *JSONCodec[T]) OnError(err error) {
+	c.Handler.OnError(err)
+}
+
+// SendJSON marshals v and sends it as a text frame.
+func SendJSON(conn server.WSConn, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return conn.Send(string(data))
+}
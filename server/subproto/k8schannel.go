@@ -0,0 +1,82 @@
+package subproto
+
+import (
+	"encoding/base64"
+	"fmt"
+
+	"github.com/mrborghini/simple-go-websockets/server"
+)
+
+// Channel identifies a stream multiplexed over a single channel.k8s.io
+// connection, matching the Kubernetes exec/attach subprotocol.
+type Channel byte
+
+const (
+	ChannelStdin  Channel = 0
+	ChannelStdout Channel = 1
+	ChannelStderr Channel = 2
+	ChannelError  Channel = 3
+	ChannelResize Channel = 4
+)
+
+// K8sChannelHandler receives demultiplexed channel.k8s.io stream data.
+type K8sChannelHandler interface {
+	OnConnect(server.WSConn)
+	OnChannelData(conn server.WSConn, channel Channel, data []byte)
+	OnClose(server.WSConn, uint16, string)
+	OnError(error)
+}
+
+// K8sChannelCodec adapts a K8sChannelHandler into a server.WSHandler,
+// implementing the channel.k8s.io and base64.channel.k8s.io subprotocols
+// Kubernetes uses to bridge browser terminals to kubectl exec/attach: every
+// message carries a channel number as its first byte, with the rest of the
+// payload belonging to that stream.
+type K8sChannelCodec struct {
+	Handler K8sChannelHandler
+	// Base64 selects the base64.channel.k8s.io variant, where the
+	// channel number is an ASCII digit and the remaining payload is
+	// base64-encoded, all carried in text frames.
+	Base64 bool
+}
+
+func (c *K8sChannelCodec) OnConnect(conn server.WSConn) {
+	c.Handler.OnConnect(conn)
+}
+
+func (c *K8sChannelCodec) OnBinary(conn server.WSConn, data []byte) {
+	if c.Base64 || len(data) == 0 {
+		return
+	}
+	c.Handler.OnChannelData(conn, Channel(data[0]), data[1:])
+}
+
+func (c *K8sChannelCodec) OnMessage(conn server.WSConn, message string) {
+	if !c.Base64 || len(message) == 0 {
+		return
+	}
+	decoded, err := base64.StdEncoding.DecodeString(message[1:])
+	if err != nil {
+		c.Handler.OnError(err)
+		return
+	}
+	c.Handler.OnChannelData(conn, Channel(message[0]-'0'), decoded)
+}
+
+func (c *K8sChannelCodec) OnPong(server.WSConn, []byte) {}
+
+func (c *K8sChannelCodec) OnClose(conn server.WSConn, code uint16, reason string) {
+	c.Handler.OnClose(conn, code, reason)
+}
+
+func (c *K8sChannelCodec) OnError(err error) {
+	c.Handler.OnError(err)
+}
+
+// SendChannel writes data to the given channel.k8s.io stream.
+func (c *K8sChannelCodec) SendChannel(conn server.WSConn, channel Channel, data []byte) error {
+	if c.Base64 {
+		return conn.Send(fmt.Sprintf("%d%s", channel, base64.StdEncoding.EncodeToString(data)))
+	}
+	return conn.SendBinary(append([]byte{byte(channel)}, data...))
+}
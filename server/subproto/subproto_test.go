@@ -0,0 +1,119 @@
+package subproto
+
+import (
+	"testing"
+
+	"github.com/mrborghini/simple-go-websockets/server"
+)
+
+type fakeJSONHandler[T any] struct {
+	received []T
+	errs     []error
+}
+
+func (h *fakeJSONHandler[T]) OnConnect(server.WSConn)               {}
+func (h *fakeJSONHandler[T]) OnJSON(conn server.WSConn, v T)        { h.received = append(h.received, v) }
+func (h *fakeJSONHandler[T]) OnClose(server.WSConn, uint16, string) {}
+func (h *fakeJSONHandler[T]) OnError(err error)                     { h.errs = append(h.errs, err) }
+
+type chatMessage struct {
+	Text string `json:"text"`
+}
+
+func TestJSONCodecDecodesTextFrame(t *testing.T) {
+	handler := &fakeJSONHandler[chatMessage]{}
+	codec := &JSONCodec[chatMessage]{Handler: handler}
+
+	codec.OnMessage(server.WSConn{}, `{"text":"hi"}`)
+
+	if len(handler.received) != 1 || handler.received[0].Text != "hi" {
+		t.Fatalf("got %+v, want one message with text %q", handler.received, "hi")
+	}
+	if len(handler.errs) != 0 {
+		t.Fatalf("unexpected errors: %v", handler.errs)
+	}
+}
+
+func TestJSONCodecReportsDecodeErrors(t *testing.T) {
+	handler := &fakeJSONHandler[chatMessage]{}
+	codec := &JSONCodec[chatMessage]{Handler: handler}
+
+	codec.OnBinary(server.WSConn{}, []byte("not json"))
+
+	if len(handler.received) != 0 {
+		t.Fatalf("got %+v, want no decoded messages", handler.received)
+	}
+	if len(handler.errs) != 1 {
+		t.Fatalf("got %d errors, want 1", len(handler.errs))
+	}
+}
+
+type fakeK8sHandler struct {
+	channel Channel
+	data    []byte
+	calls   int
+	err     error
+}
+
+func (h *fakeK8sHandler) OnConnect(server.WSConn)               {}
+func (h *fakeK8sHandler) OnClose(server.WSConn, uint16, string) {}
+func (h *fakeK8sHandler) OnError(err error)                     { h.err = err }
+func (h *fakeK8sHandler) OnChannelData(conn server.WSConn, channel Channel, data []byte) {
+	h.calls++
+	h.channel = channel
+	h.data = append([]byte(nil), data...)
+}
+
+func TestK8sChannelCodecDemuxesBinaryFrame(t *testing.T) {
+	handler := &fakeK8sHandler{}
+	codec := &K8sChannelCodec{Handler: handler}
+
+	codec.OnBinary(server.WSConn{}, append([]byte{byte(ChannelStdout)}, "hello"...))
+
+	if handler.calls != 1 {
+		t.Fatalf("got %d calls to OnChannelData, want 1", handler.calls)
+	}
+	if handler.channel != ChannelStdout || string(handler.data) != "hello" {
+		t.Fatalf("got channel=%d data=%q, want channel=%d data=%q", handler.channel, handler.data, ChannelStdout, "hello")
+	}
+}
+
+func TestK8sChannelCodecIgnoresBinaryFrameWhenBase64(t *testing.T) {
+	handler := &fakeK8sHandler{}
+	codec := &K8sChannelCodec{Handler: handler, Base64: true}
+
+	codec.OnBinary(server.WSConn{}, append([]byte{byte(ChannelStdout)}, "hello"...))
+
+	if handler.calls != 0 {
+		t.Fatalf("got %d calls to OnChannelData, want 0 for a base64 codec receiving a binary frame", handler.calls)
+	}
+}
+
+func TestK8sChannelCodecDemuxesBase64TextFrame(t *testing.T) {
+	handler := &fakeK8sHandler{}
+	codec := &K8sChannelCodec{Handler: handler, Base64: true}
+
+	// Channel 1 (stdout), base64("hello") = "aGVsbG8=".
+	codec.OnMessage(server.WSConn{}, "1aGVsbG8=")
+
+	if handler.calls != 1 {
+		t.Fatalf("got %d calls to OnChannelData, want 1", handler.calls)
+	}
+	if handler.channel != ChannelStdout || string(handler.data) != "hello" {
+		t.Fatalf("got channel=%d data=%q, want channel=%d data=%q", handler.channel, handler.data, ChannelStdout, "hello")
+	}
+}
+
+func TestK8sChannelCodecReportsBadBase64(t *testing.T) {
+	handler := &fakeK8sHandler{}
+	codec := &K8sChannelCodec{Handler: handler, Base64: true}
+
+	codec.OnMessage(server.WSConn{}, "1not-valid-base64!!!")
+
+	if handler.calls != 0 {
+		t.Fatalf("got %d calls to OnChannelData, want 0", handler.calls)
+	}
+	if handler.err == nil {
+		t.Fatal("expected OnError to be called for invalid base64 payload")
+	}
+}
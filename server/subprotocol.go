@@ -0,0 +1,34 @@
+package server
+
+import "strings"
+
+// parseSubprotocols parses a comma-separated Sec-WebSocket-Protocol header
+// into the list of subprotocols the client offered, in its preference
+// order.
+func parseSubprotocols(header string) []string {
+	if header == "" {
+		return nil
+	}
+
+	var offered []string
+	for _, p := range strings.Split(header, ",") {
+		p = strings.TrimSpace(p)
+		if p != "" {
+			offered = append(offered, p)
+		}
+	}
+	return offered
+}
+
+// negotiateSubprotocol returns the first of supported (the server's own
+// preference order) that the client also offered, or "" if none match.
+func negotiateSubprotocol(offered, supported []string) string {
+	for _, s := range supported {
+		for _, o := range offered {
+			if s == o {
+				return s
+			}
+		}
+	}
+	return ""
+}